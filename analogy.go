@@ -0,0 +1,223 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package go2vec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gonum/blas"
+)
+
+// analogyEpsilon avoids division by zero in the 3CosMul scoring method.
+const analogyEpsilon = 1e-6
+
+// AnalogyMethod selects the scoring method used by AnalogyWith and
+// AnalogyBatch to rank analogy candidates.
+type AnalogyMethod int
+
+const (
+	// ThreeCosAdd scores a candidate x as the dot product with
+	// '(word2 - word1) + word3', the traditional vector-offset method.
+	ThreeCosAdd AnalogyMethod = iota
+
+	// ThreeCosMul scores a candidate x as
+	// '(cos(x, word2) * cos(x, word3)) / (cos(x, word1) + ε)', which
+	// reduces the tendency of one large similarity to dominate the sum
+	// used by ThreeCosAdd.
+	ThreeCosMul
+)
+
+// AnalogyOptions controls the behavior of AnalogyWith and AnalogyBatch.
+type AnalogyOptions struct {
+	// Keep1, Keep2 and Keep3 indicate that the corresponding query word
+	// should not be excluded from the results. By default (the zero
+	// value) all three query words are excluded, as with Analogy.
+	Keep1, Keep2, Keep3 bool
+
+	// Skip is an additional set of words to exclude from the results,
+	// beyond the query words.
+	Skip map[string]interface{}
+
+	// Method selects the scoring method. The default is ThreeCosAdd.
+	Method AnalogyMethod
+
+	// Limit is the maximum number of results to return.
+	Limit int
+}
+
+// AnalogyWith performs a word analogy query like Analogy, but lets the
+// caller control which query words are excluded from the results and
+// which scoring method is used.
+func (e *Embeddings) AnalogyWith(word1, word2, word3 string, opts AnalogyOptions) ([]WordSimilarity, error) {
+	v1, idx1, err := e.embeddingFor(word1)
+	if err != nil {
+		return nil, err
+	}
+
+	v2, idx2, err := e.embeddingFor(word2)
+	if err != nil {
+		return nil, err
+	}
+
+	v3, idx3, err := e.embeddingFor(word3)
+	if err != nil {
+		return nil, err
+	}
+
+	skips := e.analogySkips(idx1, idx2, idx3, opts)
+
+	if opts.Method == ThreeCosMul {
+		scores, err := e.threeCosMulScores(v1, v2, v3)
+		if err != nil {
+			return nil, err
+		}
+
+		return e.rank(scores, skips, opts.Limit), nil
+	}
+
+	v4 := plus(minus(v2, v1), v3)
+	return e.similarity(v4, skips, opts.Limit)
+}
+
+// AnalogyBatch evaluates many analogy queries at once. For the default
+// ThreeCosAdd method, the composed query vectors are stacked into a
+// matrix and scored against the embedding matrix with a single Sgemm
+// call, which is substantially faster than calling AnalogyWith in a loop
+// when evaluating a large analogy test set. AnalogyBatch falls back to
+// looping AnalogyWith for ThreeCosMul, since that method does not reduce
+// to a single matrix multiplication.
+func (e *Embeddings) AnalogyBatch(queries [][3]string, opts AnalogyOptions) ([][]WordSimilarity, error) {
+	if opts.Method == ThreeCosMul {
+		results := make([][]WordSimilarity, len(queries))
+		for i, query := range queries {
+			result, err := e.AnalogyWith(query[0], query[1], query[2], opts)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+		}
+		return results, nil
+	}
+
+	dense, ok := e.storage.(*DenseStorage)
+	if !ok {
+		return nil, fmt.Errorf("go2vec: AnalogyBatch requires dense storage")
+	}
+
+	sgemm, ok := e.blas.(blas.Float32Level3)
+	if !ok {
+		return nil, fmt.Errorf("go2vec: AnalogyBatch requires a BLAS implementation that provides Sgemm")
+	}
+
+	queryMatrix := make([]float32, 0, len(queries)*e.embedSize)
+	skips := make([]map[int]interface{}, len(queries))
+
+	for i, query := range queries {
+		v1, idx1, err := e.embeddingFor(query[0])
+		if err != nil {
+			return nil, err
+		}
+
+		v2, idx2, err := e.embeddingFor(query[1])
+		if err != nil {
+			return nil, err
+		}
+
+		v3, idx3, err := e.embeddingFor(query[2])
+		if err != nil {
+			return nil, err
+		}
+
+		queryMatrix = append(queryMatrix, plus(minus(v2, v1), v3)...)
+		skips[i] = e.analogySkips(idx1, idx2, idx3, opts)
+	}
+
+	vocabSize := dense.Size()
+	dps := make([]float32, len(queries)*vocabSize)
+	sgemm.Sgemm(blas.NoTrans, blas.Trans, len(queries), vocabSize, e.embedSize,
+		1, queryMatrix, e.embedSize, dense.Raw(), e.embedSize, 0, dps, vocabSize)
+
+	results := make([][]WordSimilarity, len(queries))
+	for i := range queries {
+		results[i] = e.rank(dps[i*vocabSize:(i+1)*vocabSize], skips[i], opts.Limit)
+	}
+
+	return results, nil
+}
+
+// analogySkips builds the set of vocabulary indices that should be
+// excluded from analogy results, based on which query words resolved to
+// an in-vocabulary index, opts.Keep1..3 and opts.Skip.
+func (e *Embeddings) analogySkips(idx1, idx2, idx3 int, opts AnalogyOptions) map[int]interface{} {
+	skips := map[int]interface{}{}
+
+	if !opts.Keep1 && idx1 >= 0 {
+		skips[idx1] = nil
+	}
+	if !opts.Keep2 && idx2 >= 0 {
+		skips[idx2] = nil
+	}
+	if !opts.Keep3 && idx3 >= 0 {
+		skips[idx3] = nil
+	}
+
+	for word := range opts.Skip {
+		if idx, ok := e.indices[word]; ok {
+			skips[idx] = nil
+		}
+	}
+
+	return skips
+}
+
+// threeCosMulScores scores every vocabulary embedding x against the query
+// words using the 3CosMul method.
+func (e *Embeddings) threeCosMulScores(v1, v2, v3 Embedding) ([]float32, error) {
+	dps1, err := e.scores(v1)
+	if err != nil {
+		return nil, err
+	}
+
+	dps2, err := e.scores(v2)
+	if err != nil {
+		return nil, err
+	}
+
+	dps3, err := e.scores(v3)
+	if err != nil {
+		return nil, err
+	}
+
+	n1, n2, n3 := norm(v1), norm(v2), norm(v3)
+
+	scores := make([]float32, len(dps1))
+	for idx := range scores {
+		rowNorm := norm(e.lookupIdx(idx))
+
+		cos1 := dps1[idx] / (rowNorm*n1 + analogyEpsilon)
+		cos2 := dps2[idx] / (rowNorm*n2 + analogyEpsilon)
+		cos3 := dps3[idx] / (rowNorm*n3 + analogyEpsilon)
+
+		scores[idx] = (cos2 * cos3) / (cos1 + analogyEpsilon)
+	}
+
+	return scores, nil
+}
+
+// norm returns the L2 norm of an embedding.
+func norm(v []float32) float32 {
+	return float32(math.Sqrt(float64(dotProduct(v, v))))
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/danieldk/go2vec/v2"
+	"github.com/danieldk/go2vec/v2/cmd/common"
+)
+
+var formatsByName = map[string]go2vec.Format{
+	"word2vec-bin":  go2vec.FormatWord2VecBinary,
+	"word2vec-text": go2vec.FormatWord2VecText,
+	"glove-text":    go2vec.FormatGloveText,
+	"fasttext-vec":  go2vec.FormatFastTextVec,
+	"fasttext-bin":  go2vec.FormatFastTextBin,
+	"pq-bin":        go2vec.FormatPQBinary,
+}
+
+func main() {
+	to := flag.String("to", "", "output format: word2vec-bin, word2vec-text, glove-text, fasttext-vec, fasttext-bin, pq-bin")
+	flag.Parse()
+
+	toFormat, ok := formatsByName[*to]
+	if flag.NArg() != 2 || !ok {
+		fmt.Fprintln(os.Stderr, "Usage: go2vec-convert -to <format> input output")
+		os.Exit(1)
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	common.ExitIfError("Cannot open input file: ", err)
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	fromFormat, err := go2vec.SniffFormat(r)
+	common.ExitIfError("Cannot detect input format: ", err)
+
+	embeds, err := go2vec.ReadEmbeddings(r, fromFormat, go2vec.ReadOptions{})
+	common.ExitIfError("Cannot read embeddings: ", err)
+
+	out, err := os.Create(flag.Arg(1))
+	common.ExitIfError("Cannot create output file: ", err)
+	defer out.Close()
+
+	err = go2vec.WriteEmbeddings(out, toFormat, embeds)
+	common.ExitIfError("Cannot write embeddings: ", err)
+}
@@ -0,0 +1,413 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package go2vec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format identifies an on-disk representation of word embeddings.
+type Format int
+
+const (
+	// FormatWord2VecBinary is the binary format written by word2vec.
+	FormatWord2VecBinary Format = iota
+
+	// FormatWord2VecText is the plain text format written by word2vec,
+	// with a "vocab_size dim" header line followed by one
+	// "word f1 f2 ... fdim" line per word.
+	FormatWord2VecText
+
+	// FormatGloveText is the plain text format written by GloVe. It is
+	// like FormatWord2VecText, but without a header line.
+	FormatGloveText
+
+	// FormatFastTextVec is the ".vec" plain text format written by
+	// fastText. Its layout is identical to FormatWord2VecText.
+	FormatFastTextVec
+
+	// FormatFastTextBin is this package's fastText-style binary format,
+	// which also stores the n-gram buckets needed to look up embeddings
+	// for out-of-vocabulary words. It is not the ".bin" layout that
+	// fastText itself writes. See ReadFastTextBinary.
+	FormatFastTextBin
+
+	// FormatPQBinary is this package's binary format for product-quantized
+	// embeddings produced by TrainPQ. See ReadPQBinary.
+	FormatPQBinary
+)
+
+// String returns a human-readable name for the format.
+func (f Format) String() string {
+	switch f {
+	case FormatWord2VecBinary:
+		return "word2vec binary"
+	case FormatWord2VecText:
+		return "word2vec text"
+	case FormatGloveText:
+		return "GloVe text"
+	case FormatFastTextVec:
+		return "fastText vec"
+	case FormatFastTextBin:
+		return "fastText binary"
+	case FormatPQBinary:
+		return "product-quantized binary"
+	default:
+		return fmt.Sprintf("unknown format (%d)", int(f))
+	}
+}
+
+// ReadOptions controls how ReadEmbeddings parses an embeddings file.
+type ReadOptions struct {
+	// Normalize requests that every embedding is normalized using its
+	// L2 norm.
+	Normalize bool
+
+	// Lossy skips lines or rows that cannot be parsed, rather than
+	// failing the read with an error. Only text formats can be
+	// meaningfully read in a lossy fashion; a corrupt binary file will
+	// still result in an error. The one exception is the word2vec binary
+	// format: with Lossy set, reading stops at the first vector that
+	// cannot be read, and the words read before it are returned.
+	Lossy bool
+
+	// MaxWords caps the number of words that are read. A value of zero
+	// or less means that all words are read.
+	MaxWords int
+}
+
+// ReadEmbeddings reads word embeddings of the given format from r.
+func ReadEmbeddings(r io.Reader, format Format, opts ReadOptions) (*Embeddings, error) {
+	br := bufio.NewReader(r)
+
+	switch format {
+	case FormatWord2VecBinary:
+		return readWord2VecBinary(br, opts)
+	case FormatWord2VecText, FormatFastTextVec:
+		return readWord2VecText(br, opts)
+	case FormatGloveText:
+		return readGloveText(br, opts)
+	case FormatFastTextBin:
+		return readFastTextBinary(br, opts)
+	case FormatPQBinary:
+		return ReadPQBinary(br, opts)
+	default:
+		return nil, fmt.Errorf("go2vec: unknown format: %s", format)
+	}
+}
+
+// WriteEmbeddings writes embeds to w in the given format.
+func WriteEmbeddings(w io.Writer, format Format, embeds *Embeddings) error {
+	bw := bufio.NewWriter(w)
+
+	var err error
+	switch format {
+	case FormatWord2VecBinary:
+		err = embeds.Write(bw)
+	case FormatWord2VecText, FormatFastTextVec:
+		err = writeTextEmbeddings(bw, embeds, true)
+	case FormatGloveText:
+		err = writeTextEmbeddings(bw, embeds, false)
+	case FormatFastTextBin:
+		err = writeFastTextBinary(bw, embeds)
+	case FormatPQBinary:
+		err = writePQBinary(bw, embeds)
+	default:
+		return fmt.Errorf("go2vec: unknown format: %s", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// SniffFormat inspects the header of r without consuming it, to determine
+// which Format it is most likely encoded in. Since the FormatWord2VecText
+// and FormatFastTextVec layouts are identical, SniffFormat can never
+// return FormatFastTextVec -- pass the format explicitly to ReadEmbeddings
+// if the source of the file is known to be fastText.
+func SniffFormat(r *bufio.Reader) (Format, error) {
+	buf, _ := r.Peek(4096)
+
+	if len(buf) >= len(fastTextBinMagic) && bytes.Equal(buf[:len(fastTextBinMagic)], fastTextBinMagic[:]) {
+		return FormatFastTextBin, nil
+	}
+	if len(buf) >= len(pqBinMagic) && bytes.Equal(buf[:len(pqBinMagic)], pqBinMagic[:]) {
+		return FormatPQBinary, nil
+	}
+
+	nlIdx := bytes.IndexByte(buf, '\n')
+	if nlIdx < 0 {
+		return 0, fmt.Errorf("go2vec: could not determine the file format")
+	}
+
+	header := strings.Fields(string(buf[:nlIdx]))
+	if len(header) != 2 {
+		return FormatGloveText, nil
+	}
+	if _, err := strconv.Atoi(header[0]); err != nil {
+		return FormatGloveText, nil
+	}
+	if _, err := strconv.Atoi(header[1]); err != nil {
+		return FormatGloveText, nil
+	}
+
+	// The header looks like a "vocab_size dim" line. Peek past the first
+	// word of the first vector to see whether its values are printable
+	// ASCII (a text format) or raw binary floats.
+	rest := buf[nlIdx+1:]
+	spaceIdx := bytes.IndexByte(rest, ' ')
+	if spaceIdx < 0 || spaceIdx+5 > len(rest) {
+		return FormatWord2VecBinary, nil
+	}
+
+	for _, b := range rest[spaceIdx+1 : spaceIdx+5] {
+		if b < 0x20 || b > 0x7e {
+			return FormatWord2VecBinary, nil
+		}
+	}
+
+	return FormatWord2VecText, nil
+}
+
+func readWord2VecBinary(r *bufio.Reader, opts ReadOptions) (*Embeddings, error) {
+	var nWords uint64
+	if _, err := fmt.Fscanf(r, "%d", &nWords); err != nil {
+		return nil, err
+	}
+
+	var vSize uint64
+	if _, err := fmt.Fscanf(r, "%d", &vSize); err != nil {
+		return nil, err
+	}
+
+	n := int(nWords)
+	if opts.MaxWords > 0 && opts.MaxWords < n {
+		n = opts.MaxWords
+	}
+
+	matrix := make([]float32, 0, n*int(vSize))
+	indices := make(map[string]int)
+	words := make([]string, 0, n)
+
+	for idx := 0; idx < n; idx++ {
+		word, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		word = strings.TrimSpace(word)
+
+		embedding := make([]float32, vSize)
+		if err := binary.Read(r, binary.LittleEndian, embedding); err != nil {
+			if opts.Lossy {
+				break
+			}
+			return nil, err
+		}
+
+		if opts.Normalize {
+			normalizeEmbeddings(embedding)
+		}
+
+		indices[word] = len(words)
+		words = append(words, word)
+		matrix = append(matrix, embedding...)
+	}
+
+	return &Embeddings{
+		blas:      cblasImplementation(),
+		storage:   NewDenseStorage(matrix, int(vSize)),
+		embedSize: int(vSize),
+		indices:   indices,
+		words:     words,
+	}, nil
+}
+
+func readWord2VecText(r *bufio.Reader, opts ReadOptions) (*Embeddings, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+
+	header := strings.Fields(line)
+	if len(header) != 2 {
+		return nil, fmt.Errorf("go2vec: invalid word2vec text header: %q", line)
+	}
+
+	dim, err := strconv.Atoi(header[1])
+	if err != nil {
+		return nil, fmt.Errorf("go2vec: invalid word2vec text header: %q", line)
+	}
+
+	return readTextEmbeddings(r, dim, opts)
+}
+
+func readGloveText(r *bufio.Reader, opts ReadOptions) (*Embeddings, error) {
+	return readTextEmbeddings(r, 0, opts)
+}
+
+// readTextEmbeddings reads "word f1 f2 ... fdim" lines until EOF. A dim of
+// zero means that the dimensionality is inferred from the first line.
+func readTextEmbeddings(r *bufio.Reader, dim int, opts ReadOptions) (*Embeddings, error) {
+	indices := make(map[string]int)
+	var words []string
+	var matrix []float32
+
+	scanner := bufio.NewScanner(r)
+	// Text embedding files can have very long lines for high-dimensional
+	// embeddings, so grow the scanner's buffer beyond its small default.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			if opts.Lossy {
+				continue
+			}
+			return nil, fmt.Errorf("go2vec: malformed line: %q", line)
+		}
+
+		word := fields[0]
+		values := fields[1:]
+
+		if dim == 0 {
+			dim = len(values)
+		} else if len(values) != dim {
+			if opts.Lossy {
+				continue
+			}
+			return nil, fmt.Errorf("go2vec: expected %d values for %q, got %d", dim, word, len(values))
+		}
+
+		embedding := make([]float32, dim)
+		malformed := false
+		for i, v := range values {
+			f, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				if opts.Lossy {
+					malformed = true
+					break
+				}
+				return nil, err
+			}
+			embedding[i] = float32(f)
+		}
+		if malformed {
+			continue
+		}
+
+		if opts.Normalize {
+			normalizeEmbeddings(embedding)
+		}
+
+		indices[word] = len(words)
+		words = append(words, word)
+		matrix = append(matrix, embedding...)
+
+		if opts.MaxWords > 0 && len(words) >= opts.MaxWords {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &Embeddings{
+		blas:      cblasImplementation(),
+		storage:   NewDenseStorage(matrix, dim),
+		embedSize: dim,
+		indices:   indices,
+		words:     words,
+	}, nil
+}
+
+func writeTextEmbeddings(w *bufio.Writer, embeds *Embeddings, header bool) error {
+	if header {
+		if _, err := fmt.Fprintf(w, "%d %d\n", len(embeds.words), embeds.embedSize); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	embeds.Iterate(func(word string, embedding []float32) bool {
+		if _, err = fmt.Fprint(w, word); err != nil {
+			return false
+		}
+
+		for _, v := range embedding {
+			if _, err = fmt.Fprintf(w, " %f", v); err != nil {
+				return false
+			}
+		}
+
+		_, err = fmt.Fprint(w, "\n")
+		return err == nil
+	})
+
+	return err
+}
+
+func writeFastTextBinary(w *bufio.Writer, embeds *Embeddings) error {
+	if embeds.ngramMatrix == nil {
+		return fmt.Errorf("go2vec: embeddings have no subword information to write as fastText binary")
+	}
+
+	ngramIndexer, ok := embeds.subwordIndexer.(*NGramIndexer)
+	if !ok {
+		return fmt.Errorf("go2vec: embeddings do not use an NGramIndexer")
+	}
+
+	if _, err := w.Write(fastTextBinMagic[:]); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%d %d\n", len(embeds.words), embeds.embedSize); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(ngramIndexer.MinN())); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(ngramIndexer.MaxN())); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(embeds.nBuckets)); err != nil {
+		return err
+	}
+
+	for idx, word := range embeds.words {
+		if _, err := w.WriteString(word + " "); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, embeds.lookupIdx(idx)); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(w, binary.LittleEndian, embeds.ngramMatrix)
+}
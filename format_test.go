@@ -0,0 +1,75 @@
+package go2vec
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestSniffFormatGlove(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("apple 1.0 0.0\npear 0.8 0.1\n")))
+
+	format, err := SniffFormat(r)
+	if err != nil {
+		t.Fatalf("SniffFormat should not fail, was: %s", err)
+	}
+
+	if format != FormatGloveText {
+		t.Errorf("Format should be %s, was %s", FormatGloveText, format)
+	}
+}
+
+func TestSniffFormatWord2VecText(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("2 2\napple 1.0 0.0\npear 0.8 0.1\n")))
+
+	format, err := SniffFormat(r)
+	if err != nil {
+		t.Fatalf("SniffFormat should not fail, was: %s", err)
+	}
+
+	if format != FormatWord2VecText {
+		t.Errorf("Format should be %s, was %s", FormatWord2VecText, format)
+	}
+}
+
+func TestReadWriteWord2VecText(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("2 2\napple 1.000000 0.000000\npear 0.800000 0.100000\n")
+
+	embeds, err := ReadEmbeddings(&buf, FormatWord2VecText, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadEmbeddings should not fail, was: %s", err)
+	}
+
+	if embeds.Size() != 2 {
+		t.Errorf("Embeddings should have size 2, was %d", embeds.Size())
+	}
+
+	var out bytes.Buffer
+	if err := WriteEmbeddings(&out, FormatWord2VecText, embeds); err != nil {
+		t.Fatalf("WriteEmbeddings should not fail, was: %s", err)
+	}
+
+	roundtripped, err := ReadEmbeddings(&out, FormatWord2VecText, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadEmbeddings should not fail, was: %s", err)
+	}
+
+	if roundtripped.Size() != embeds.Size() {
+		t.Errorf("Roundtripped embeddings should have size %d, was %d", embeds.Size(), roundtripped.Size())
+	}
+}
+
+func TestReadEmbeddingsLossy(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("3 2\napple 1.000000 0.000000\npear bogus\nbanana 0.2 1.0\n")
+
+	embeds, err := ReadEmbeddings(&buf, FormatWord2VecText, ReadOptions{Lossy: true})
+	if err != nil {
+		t.Fatalf("ReadEmbeddings should not fail, was: %s", err)
+	}
+
+	if embeds.Size() != 2 {
+		t.Errorf("Embeddings should have size 2, was %d", embeds.Size())
+	}
+}
@@ -16,11 +16,11 @@ package go2vec
 
 import (
 	"bufio"
+	"container/heap"
 	"encoding/binary"
 	"fmt"
 	"math"
 	"sort"
-	"strings"
 
 	"github.com/gonum/blas"
 	cblas "github.com/gonum/blas/cgo"
@@ -44,10 +44,17 @@ type Embedding []float32
 // similar words).
 type Embeddings struct {
 	blas      blas.Float32Level2
-	matrix    []float32
+	storage   Storage
 	embedSize int
 	indices   map[string]int
 	words     []string
+
+	// subwordIndexer and ngramMatrix are only set when the embeddings were
+	// loaded with ReadFastTextBinary. They allow Similarity, Analogy and
+	// Embedding to synthesize embeddings for out-of-vocabulary words.
+	subwordIndexer SubwordIndexer
+	ngramMatrix    []float32
+	nBuckets       int
 }
 
 // NewEmbeddings creates a set of word embeddings from scratch. This constructor
@@ -55,53 +62,27 @@ type Embeddings struct {
 func NewEmbeddings(embedSize int) *Embeddings {
 	return &Embeddings{
 		blas:      cblas.Implementation{},
-		matrix:    make([]float32, 0),
+		storage:   NewDenseStorage(make([]float32, 0), embedSize),
 		embedSize: embedSize,
 		indices:   make(map[string]int),
 		words:     make([]string, 0),
 	}
 }
 
+// cblasImplementation returns the default C BLAS implementation used by
+// the readers in this package.
+func cblasImplementation() blas.Float32Level2 {
+	return cblas.Implementation{}
+}
+
 // ReadWord2VecBinary reads word embeddings from a binary file that is produced
 // by word2vec. The embeddings can be normalized using their L2 norms.
+//
+// This is a convenience wrapper around ReadEmbeddings for the common case.
+// Use ReadEmbeddings directly to control lossy parsing or to cap the number
+// of words that are read.
 func ReadWord2VecBinary(r *bufio.Reader, normalize bool) (*Embeddings, error) {
-	var nWords uint64
-	if _, err := fmt.Fscanf(r, "%d", &nWords); err != nil {
-		return nil, err
-	}
-
-	var vSize uint64
-	if _, err := fmt.Fscanf(r, "%d", &vSize); err != nil {
-		return nil, err
-	}
-
-	matrix := make([]float32, nWords*vSize)
-	indices := make(map[string]int)
-	words := make([]string, nWords)
-
-	for idx := 0; idx < int(nWords); idx++ {
-		word, err := r.ReadString(' ')
-		word = strings.TrimSpace(word)
-		indices[word] = idx
-		words[idx] = word
-
-		start := idx * int(vSize)
-		if err = binary.Read(r, binary.LittleEndian, matrix[start:start+int(vSize)]); err != nil {
-			return nil, err
-		}
-
-		if normalize {
-			normalizeEmbeddings(matrix[start : start+int(vSize)])
-		}
-	}
-
-	return &Embeddings{
-		blas:      cblas.Implementation{},
-		matrix:    matrix,
-		embedSize: int(vSize),
-		indices:   indices,
-		words:     words,
-	}, nil
+	return readWord2VecBinary(r, ReadOptions{Normalize: normalize})
 }
 
 // Write embeddings to a binary file accepted by word2vec
@@ -142,35 +123,12 @@ func (e *Embeddings) Write(w *bufio.Writer) error {
 // the most similar to e4 are returned.
 //
 // The query words are never returned as a result.
+//
+// This is a convenience wrapper around AnalogyWith for the common case.
+// Use AnalogyWith directly to change which query words are excluded from
+// the results, or to use the 3CosMul scoring method.
 func (e *Embeddings) Analogy(word1, word2, word3 string, limit int) ([]WordSimilarity, error) {
-	idx1, ok := e.indices[word1]
-	if !ok {
-		return nil, fmt.Errorf("Unknown word: %s", word1)
-	}
-
-	idx2, ok := e.indices[word2]
-	if !ok {
-		return nil, fmt.Errorf("Unknown word: %s", word2)
-	}
-
-	idx3, ok := e.indices[word3]
-	if !ok {
-		return nil, fmt.Errorf("Unknown word: %s", word3)
-	}
-
-	v1 := e.lookupIdx(idx1)
-	v2 := e.lookupIdx(idx2)
-	v3 := e.lookupIdx(idx3)
-
-	v4 := plus(minus(v2, v1), v3)
-
-	skips := map[int]interface{}{
-		idx1: nil,
-		idx2: nil,
-		idx3: nil,
-	}
-
-	return e.similarity(v4, skips, limit)
+	return e.AnalogyWith(word1, word2, word3, AnalogyOptions{Limit: limit})
 }
 
 // SetBLAS sets the BLAS implementation to use (default: C BLAS).
@@ -189,19 +147,27 @@ func (e *Embeddings) Iterate(f IterFunc) {
 
 // Put adds a word embedding to the word embeddings. The new word can be
 // queried after the call returns.
+//
+// Put requires dense storage; it cannot be used with product-quantized
+// embeddings produced by TrainPQ.
 func (e *Embeddings) Put(word string, embedding []float32) error {
 	if len(embedding) != e.embedSize {
-		return fmt.Errorf("Expected embedding size: %d, got: %d", e.embedSize, len(embedding))
+		return fmt.Errorf("go2vec: expected embedding size %d, got %d", e.embedSize, len(embedding))
+	}
+
+	dense, ok := e.storage.(*DenseStorage)
+	if !ok {
+		return fmt.Errorf("go2vec: Put requires dense storage")
 	}
 
 	if idx, ok := e.indices[word]; ok {
 		// The word is already known, replace its embedding.
-		copy(e.matrix[idx*e.embedSize:], embedding)
+		copy(dense.matrix[idx*e.embedSize:], embedding)
 	} else {
 		// The word is not known, add it and allocate memory.
 		e.indices[word] = len(e.words)
 		e.words = append(e.words, word)
-		e.matrix = append(e.matrix, embedding...)
+		dense.matrix = append(dense.matrix, embedding...)
 	}
 
 	return nil
@@ -213,16 +179,17 @@ func (e *Embeddings) Put(word string, embedding []float32) error {
 //
 // The query word is never returned as a result.
 func (e Embeddings) Similarity(word string, limit int) ([]WordSimilarity, error) {
-	idx, ok := e.indices[word]
-	if !ok {
-		return nil, fmt.Errorf("Unknown word: %s", word)
+	embed, idx, err := e.embeddingFor(word)
+	if err != nil {
+		return nil, err
 	}
 
-	skips := map[int]interface{}{
-		idx: nil,
+	skips := map[int]interface{}{}
+	if idx >= 0 {
+		skips[idx] = nil
 	}
 
-	return e.similarity(e.lookupIdx(idx), skips, limit)
+	return e.similarity(embed, skips, limit)
 }
 
 // Size returns the number of words in the embeddings.
@@ -231,13 +198,21 @@ func (e *Embeddings) Size() int {
 }
 
 // Embedding returns the embedding for a particular word. If the word is
-// unknown, the second return value will be false.
+// not in the vocabulary, but the embeddings were loaded with subword
+// information (see ReadFastTextBinary), an embedding is synthesized from
+// the word's n-grams instead. The second return value is false if the
+// word is unknown and no such embedding could be synthesized.
 func (e *Embeddings) Embedding(word string) ([]float32, bool) {
 	if idx, ok := e.indices[word]; ok {
 		return e.lookupIdx(idx), true
 	}
 
-	return nil, false
+	embed, err := e.synthesizeEmbedding(word)
+	if err != nil {
+		return nil, false
+	}
+
+	return embed, true
 }
 
 // EmbeddingSize returns the embedding size.
@@ -255,28 +230,107 @@ func (e *Embeddings) WordIdx(word string) (int, bool) {
 }
 
 func (e Embeddings) similarity(embed Embedding, skips map[int]interface{}, limit int) ([]WordSimilarity, error) {
-	dps := make([]float32, e.Size())
-	e.blas.Sgemv(blas.NoTrans, int(e.Size()), int(e.EmbeddingSize()),
-		1, e.matrix, int(e.EmbeddingSize()), embed, 1, 0, dps, 1)
+	scores, err := e.scores(embed)
+	if err != nil {
+		return nil, err
+	}
 
-	var results []WordSimilarity
-	for idx, sim := range dps {
+	return e.rank(scores, skips, limit), nil
+}
+
+// rank returns the 'limit' entries of scores with the highest similarity,
+// excluding any index in skips, ordered by descending similarity.
+//
+// Ranking is done with a bounded min-heap of size 'limit', rather than
+// keeping the full score vector sorted: each score is either pushed onto
+// the heap (while it has fewer than 'limit' elements) or compared against
+// the smallest similarity seen so far and swapped in if larger.
+func (e Embeddings) rank(scores []float32, skips map[int]interface{}, limit int) []WordSimilarity {
+	h := make(similarityHeap, 0, limit)
+	for idx, sim := range scores {
 		// Skip words in the skip set.
 		if _, ok := skips[idx]; ok {
 			continue
 		}
 
-		ip := sort.Search(len(results), func(i int) bool {
-			return results[i].Similarity <= sim
-		})
-		if ip < limit {
-			results = insertWithLimit(results, limit, ip, WordSimilarity{e.words[idx], sim})
+		if len(h) < limit {
+			heap.Push(&h, WordSimilarity{e.words[idx], sim})
+		} else if len(h) > 0 && sim > h[0].Similarity {
+			h[0] = WordSimilarity{e.words[idx], sim}
+			heap.Fix(&h, 0)
 		}
 	}
 
+	results := make([]WordSimilarity, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(&h).(WordSimilarity)
+	}
+
+	return results
+}
+
+// SimilarityThreshold finds words that have embeddings with at least
+// 'minSim' similarity to that of the given word, ordered by descending
+// similarity. Unlike Similarity, the number of results is not bounded.
+//
+// The query word is never returned as a result.
+func (e Embeddings) SimilarityThreshold(word string, minSim float32) ([]WordSimilarity, error) {
+	embed, idx, err := e.embeddingFor(word)
+	if err != nil {
+		return nil, err
+	}
+
+	skips := map[int]interface{}{}
+	if idx >= 0 {
+		skips[idx] = nil
+	}
+
+	scores, err := e.scores(embed)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []WordSimilarity
+	for idx, sim := range scores {
+		if _, ok := skips[idx]; ok {
+			continue
+		}
+
+		if sim >= minSim {
+			results = append(results, WordSimilarity{e.words[idx], sim})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+
 	return results, nil
 }
 
+// scores computes the dot product of embed with every stored embedding.
+// Dense storage is scored in a single BLAS call; product-quantized
+// storage uses asymmetric distance computation instead (see PQStorage).
+// Storage that exposes neither a raw matrix nor its own scoring method
+// (e.g. MmapStorage) falls back to a plain per-row dot product.
+func (e Embeddings) scores(embed Embedding) ([]float32, error) {
+	switch storage := e.storage.(type) {
+	case *DenseStorage:
+		dps := make([]float32, storage.Size())
+		e.blas.Sgemv(blas.NoTrans, storage.Size(), storage.Dim(),
+			1, storage.Raw(), storage.Dim(), embed, 1, 0, dps, 1)
+		return dps, nil
+	case *PQStorage:
+		return storage.scoreAll(embed), nil
+	default:
+		dps := make([]float32, storage.Size())
+		for idx := range dps {
+			dps[idx] = dotProduct(embed, storage.Row(idx))
+		}
+		return dps, nil
+	}
+}
+
 func dotProduct(v, w []float32) float32 {
 	sum := float32(0)
 
@@ -287,20 +341,38 @@ func dotProduct(v, w []float32) float32 {
 	return sum
 }
 
-func insertWithLimit(slice []WordSimilarity, limit, index int, value WordSimilarity) []WordSimilarity {
-	if len(slice) < limit {
-		slice = append(slice, WordSimilarity{})
-	}
+// similarityHeap is a min-heap of WordSimilarity, ordered by ascending
+// similarity, used to maintain the top-k most similar words without
+// keeping the full result slice sorted.
+type similarityHeap []WordSimilarity
+
+func (h similarityHeap) Len() int {
+	return len(h)
+}
+
+func (h similarityHeap) Less(i, j int) bool {
+	return h[i].Similarity < h[j].Similarity
+}
+
+func (h similarityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *similarityHeap) Push(x interface{}) {
+	*h = append(*h, x.(WordSimilarity))
+}
 
-	copy(slice[index+1:], slice[index:len(slice)-1])
-	slice[index] = value
-	return slice
+func (h *similarityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
 }
 
 // Look up the embedding at the given index.
 func (e *Embeddings) lookupIdx(idx int) Embedding {
-	start := idx * e.embedSize
-	return e.matrix[start : start+e.embedSize]
+	return e.storage.Row(idx)
 }
 
 func minus(v, w []float32) []float32 {
@@ -119,6 +119,54 @@ func TestAnalogy(t *testing.T) {
 	}
 }
 
+func TestAnalogyWithKeep(t *testing.T) {
+	embeds := readEmbeddingsOrFail(t, "analogy.bin")
+	answers, err := embeds.AnalogyWith("Paris", "Frankreich", "Berlin", AnalogyOptions{
+		Keep3: true,
+		Limit: 40,
+	})
+	if err != nil {
+		t.Fatalf("AnalogyWith error should be nil, was: %s", err)
+	}
+
+	found := false
+	for _, wordSimilarity := range answers {
+		if wordSimilarity.Word == "Berlin" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Error("AnalogyWith with Keep3 should be able to return 'Berlin'")
+	}
+}
+
+func TestAnalogyBatch(t *testing.T) {
+	embeds := readEmbeddingsOrFail(t, "analogy.bin")
+
+	queries := [][3]string{
+		{"Paris", "Frankreich", "Berlin"},
+		{"Paris", "Frankreich", "Berlin"},
+	}
+
+	results, err := embeds.AnalogyBatch(queries, AnalogyOptions{Limit: 40})
+	if err != nil {
+		t.Fatalf("AnalogyBatch error should be nil, was: %s", err)
+	}
+
+	if len(results) != len(queries) {
+		t.Fatalf("AnalogyBatch should return %d results, got %d", len(queries), len(results))
+	}
+
+	for _, result := range results {
+		for idx, wordSimilarity := range result {
+			if wordSimilarity.Word != analogyOrder[idx] {
+				t.Errorf("Word at position %d should be '%s', was '%s'", idx, analogyOrder[idx], wordSimilarity.Word)
+			}
+		}
+	}
+}
+
 func TestBasicEmpty(t *testing.T) {
 	embeds := NewEmbeddings(2)
 
@@ -180,3 +228,37 @@ func TestSimilarity(t *testing.T) {
 		}
 	}
 }
+
+func TestSimilarityLimit(t *testing.T) {
+	embeds := readEmbeddingsOrFail(t, "similarity.bin")
+	answers, err := embeds.Similarity("Berlin", 5)
+	if err != nil {
+		t.Fatalf("Similarity error should be nil, was: %s", err)
+	}
+
+	if len(answers) != 5 {
+		t.Fatalf("Similarity with limit 5 should return 5 results, got %d", len(answers))
+	}
+
+	for idx, wordSimilarity := range answers {
+		if wordSimilarity.Word != similarityOrder[idx] {
+			t.Errorf("Word at position %d should be '%s', was '%s'", idx, similarityOrder[idx], wordSimilarity.Word)
+		}
+	}
+}
+
+func TestSimilarityThreshold(t *testing.T) {
+	embeds := NewEmbeddings(2)
+	embeds.Put("apple", []float32{1.0, 0.0})
+	embeds.Put("pear", []float32{0.9, 0.1})
+	embeds.Put("car", []float32{0.0, 1.0})
+
+	answers, err := embeds.SimilarityThreshold("apple", 0.5)
+	if err != nil {
+		t.Fatalf("SimilarityThreshold error should be nil, was: %s", err)
+	}
+
+	if len(answers) != 1 || answers[0].Word != "pear" {
+		t.Errorf("SimilarityThreshold(\"apple\", 0.5) should only return 'pear', got: %v", answers)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mmap memory-maps a file read-only, so that its contents can be
+// accessed without copying them into the Go heap. Open and the Close
+// method of File are implemented per-platform: syscall.Mmap is used on
+// Unix-like systems, CreateFileMapping/MapViewOfFile on Windows.
+package mmap
+
+// File is a read-only memory-mapped file.
+type File struct {
+	data []byte
+
+	// addr and handle are only used on Windows, to unmap and close the
+	// file mapping again in Close.
+	addr   uintptr
+	handle uintptr
+}
+
+// Bytes returns the memory-mapped contents of the file.
+func (f *File) Bytes() []byte {
+	return f.data
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package mmap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Open memory-maps the file at path read-only.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		return &File{}, nil
+	}
+
+	low := uint32(size)
+	high := uint32(size >> 32)
+
+	handle, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, high, low, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := syscall.MapViewOfFile(handle, syscall.FILE_MAP_READ, 0, 0, uintptr(size))
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	data := (*[1 << 40]byte)(unsafe.Pointer(addr))[:size:size]
+
+	return &File{data: data, addr: addr, handle: uintptr(handle)}, nil
+}
+
+// Close unmaps the file.
+func (f *File) Close() error {
+	if f.addr == 0 {
+		return nil
+	}
+
+	if err := syscall.UnmapViewOfFile(f.addr); err != nil {
+		return err
+	}
+
+	return syscall.CloseHandle(syscall.Handle(f.handle))
+}
@@ -0,0 +1,225 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package go2vec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/danieldk/go2vec/internal/mmap"
+)
+
+// MmapStorage is a Storage that is backed by a memory-mapped file. Rows
+// are decoded lazily on their first call to Row, since the word2vec and
+// fastText binary formats interleave words and vectors, so the vectors
+// themselves are not laid out as one contiguous matrix in the file. Once
+// decoded, a row is cached so that repeated queries -- which, via
+// Similarity and Analogy, call Row for every word in the vocabulary --
+// do not re-decode (and, if normalize is set, re-normalize) it.
+//
+// MmapStorage makes loading gigabyte-scale models close to instantaneous,
+// and -- since the mapping is shared -- lets multiple processes that load
+// the same file share its physical pages.
+type MmapStorage struct {
+	file      *mmap.File
+	offsets   []int
+	dim       int
+	normalize bool
+	cache     [][]float32
+}
+
+// Row decodes and returns the embedding at the given vocabulary index,
+// caching the result. If the storage was opened with normalize set, the
+// row is normalized after decoding, since the underlying mapping is
+// read-only and cannot be normalized in place.
+func (m *MmapStorage) Row(idx int) []float32 {
+	if row := m.cache[idx]; row != nil {
+		return row
+	}
+
+	offset := m.offsets[idx]
+	data := m.file.Bytes()[offset : offset+m.dim*4]
+
+	row := make([]float32, m.dim)
+	for i := range row {
+		row[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4 : i*4+4]))
+	}
+
+	if m.normalize {
+		normalizeEmbeddings(row)
+	}
+
+	m.cache[idx] = row
+	return row
+}
+
+// Size returns the number of embeddings in the storage.
+func (m *MmapStorage) Size() int {
+	return len(m.offsets)
+}
+
+// Dim returns the dimensionality of the embeddings.
+func (m *MmapStorage) Dim() int {
+	return m.dim
+}
+
+// Close unmaps the underlying file. The Embeddings that hold this storage
+// must not be used afterwards.
+func (m *MmapStorage) Close() error {
+	return m.file.Close()
+}
+
+// Close releases any resources that are held by the embeddings, such as a
+// memory-mapped file. Embeddings that were not loaded with a Mmap reader
+// hold no such resources, and Close is a no-op for them.
+func (e *Embeddings) Close() error {
+	if closer, ok := e.storage.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// ReadWord2VecBinaryMmap memory-maps the word2vec binary file at path,
+// instead of copying its embedding matrix into the Go heap. Since the
+// mapping is read-only, a row cannot be normalized in place; when
+// normalize is true, MmapStorage instead normalizes every row after it
+// is decoded in Row. Call Close on the returned Embeddings once they are
+// no longer needed.
+//
+// Only the word2vec binary format is mmap-able through this package for
+// now. The plain-text formats (FormatWord2VecText, FormatGloveText,
+// FormatFastTextVec) would need every row re-parsed from ASCII on each
+// Row call, which gives up most of the benefit of avoiding a copy.
+// FormatFastTextBin additionally stores an n-gram bucket matrix that
+// embeddingFor indexes directly as a []float32, so mmap-backing it needs
+// its own Storage-like abstraction rather than reusing MmapStorage; it is
+// not implemented here.
+func ReadWord2VecBinaryMmap(path string, normalize bool) (*Embeddings, error) {
+	file, err := mmap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := file.Bytes()
+
+	nWords, vSize, pos, err := readWord2VecBinaryHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	words, indices, offsets, _, err := indexWord2VecBinaryRows(data, pos, int(nWords), int(vSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Embeddings{
+		blas: cblasImplementation(),
+		storage: &MmapStorage{
+			file:      file,
+			offsets:   offsets,
+			dim:       int(vSize),
+			normalize: normalize,
+			cache:     make([][]float32, len(offsets)),
+		},
+		embedSize: int(vSize),
+		indices:   indices,
+		words:     words,
+	}, nil
+}
+
+// readWord2VecBinaryHeader parses the "nWords vSize" header of a word2vec
+// binary file out of data, returning the byte offset at which the first
+// word starts.
+func readWord2VecBinaryHeader(data []byte) (nWords, vSize uint64, pos int, err error) {
+	nWords, pos, err = parseUintToken(data, 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	vSize, pos, err = parseUintToken(data, pos)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return nWords, vSize, pos, nil
+}
+
+// indexWord2VecBinaryRows walks the n "word <vSize floats>" entries of a
+// word2vec binary file starting at byte offset pos, without copying the
+// embedding matrix. It returns the words in file order, a word-to-index
+// map, the byte offset of every word's vector, and the offset at which
+// the data following the last row starts.
+func indexWord2VecBinaryRows(data []byte, pos, n, vSize int) (words []string, indices map[string]int, offsets []int, end int, err error) {
+	rowBytes := vSize * 4
+
+	words = make([]string, 0, n)
+	indices = make(map[string]int, n)
+	offsets = make([]int, 0, n)
+
+	for i := 0; i < n; i++ {
+		start := pos
+		for pos < len(data) && data[pos] != ' ' {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, nil, nil, 0, fmt.Errorf("go2vec: truncated file")
+		}
+
+		word := strings.TrimSpace(string(data[start:pos]))
+		pos++ // skip the delimiting space
+
+		if pos+rowBytes > len(data) {
+			return nil, nil, nil, 0, fmt.Errorf("go2vec: truncated file")
+		}
+
+		indices[word] = len(words)
+		words = append(words, word)
+		offsets = append(offsets, pos)
+		pos += rowBytes
+	}
+
+	return words, indices, offsets, pos, nil
+}
+
+// parseUintToken parses a whitespace-delimited, base-10 unsigned integer
+// starting at byte offset pos, as fmt.Fscanf(r, "%d", ...) would.
+func parseUintToken(data []byte, pos int) (uint64, int, error) {
+	for pos < len(data) && isASCIISpace(data[pos]) {
+		pos++
+	}
+
+	start := pos
+	for pos < len(data) && data[pos] >= '0' && data[pos] <= '9' {
+		pos++
+	}
+
+	if start == pos {
+		return 0, 0, fmt.Errorf("go2vec: expected a number at byte offset %d", start)
+	}
+
+	var value uint64
+	for _, b := range data[start:pos] {
+		value = value*10 + uint64(b-'0')
+	}
+
+	return value, pos, nil
+}
+
+func isASCIISpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
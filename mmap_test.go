@@ -0,0 +1,98 @@
+package go2vec
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+func writeTempWord2VecBinary(t *testing.T) string {
+	embeds := NewEmbeddings(2)
+	embeds.Put("apple", []float32{1.0, 0.0})
+	embeds.Put("pear", []float32{0.8, 0.1})
+	embeds.Put("car", []float32{0.0, 1.0})
+
+	f, err := ioutil.TempFile("", "go2vec-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := embeds.Write(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestReadWord2VecBinaryMmap(t *testing.T) {
+	path := writeTempWord2VecBinary(t)
+	defer os.Remove(path)
+
+	embeds, err := ReadWord2VecBinaryMmap(path, false)
+	if err != nil {
+		t.Fatalf("ReadWord2VecBinaryMmap should not fail, was: %s", err)
+	}
+	defer embeds.Close()
+
+	if embeds.Size() != 3 {
+		t.Errorf("Embeddings should have size 3, was %d", embeds.Size())
+	}
+
+	if _, ok := embeds.Embedding("apple"); !ok {
+		t.Error("'apple' should be known")
+	}
+
+	if _, ok := embeds.Embedding("bogus"); ok {
+		t.Error("'bogus' should not be known")
+	}
+
+	similar, err := embeds.Similarity("apple", 1)
+	if err != nil {
+		t.Fatalf("Similarity should not fail, was: %s", err)
+	}
+
+	if len(similar) != 1 || similar[0].Word != "pear" {
+		t.Errorf("Most similar word to 'apple' should be 'pear', was: %v", similar)
+	}
+}
+
+func TestReadWord2VecBinaryMmapNormalize(t *testing.T) {
+	path := writeTempWord2VecBinary(t)
+	defer os.Remove(path)
+
+	embeds, err := ReadWord2VecBinaryMmap(path, true)
+	if err != nil {
+		t.Fatalf("ReadWord2VecBinaryMmap should not fail, was: %s", err)
+	}
+	defer embeds.Close()
+
+	embedding, ok := embeds.Embedding("car")
+	if !ok {
+		t.Fatal("'car' should be known")
+	}
+
+	var norm float32
+	for _, v := range embedding {
+		norm += v * v
+	}
+
+	if math.Abs(float64(norm)-1.0) > 1e-6 {
+		t.Errorf("Normalized embedding should have unit length, squared norm was %f", norm)
+	}
+}
+
+func TestEmbeddingsCloseIsNoopWithoutMmap(t *testing.T) {
+	embeds := NewEmbeddings(2)
+	embeds.Put("apple", []float32{1.0, 0.0})
+
+	if err := embeds.Close(); err != nil {
+		t.Errorf("Close on non-mmap embeddings should not fail, was: %s", err)
+	}
+}
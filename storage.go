@@ -0,0 +1,447 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package go2vec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Storage is the backing store for the embedding matrix of an Embeddings
+// instance. DenseStorage is used for embeddings that were loaded or built
+// in the usual way; PQStorage is used for embeddings that were compressed
+// with TrainPQ.
+type Storage interface {
+	// Row returns the (decoded) embedding at the given vocabulary index.
+	Row(idx int) []float32
+
+	// Size returns the number of embeddings in the storage.
+	Size() int
+
+	// Dim returns the dimensionality of the embeddings.
+	Dim() int
+}
+
+// DenseStorage stores embeddings as a single, contiguous slice of
+// float32s, one row per word. This is the storage used by all the
+// readers in this package, except for ReadPQBinary (PQStorage) and
+// ReadWord2VecBinaryMmap (MmapStorage).
+type DenseStorage struct {
+	matrix []float32
+	dim    int
+}
+
+// NewDenseStorage wraps a flat, row-major embedding matrix as a Storage.
+func NewDenseStorage(matrix []float32, dim int) *DenseStorage {
+	return &DenseStorage{matrix: matrix, dim: dim}
+}
+
+// Row returns the embedding at the given vocabulary index.
+func (d *DenseStorage) Row(idx int) []float32 {
+	start := idx * d.dim
+	return d.matrix[start : start+d.dim]
+}
+
+// Size returns the number of embeddings in the storage.
+func (d *DenseStorage) Size() int {
+	if d.dim == 0 {
+		return 0
+	}
+	return len(d.matrix) / d.dim
+}
+
+// Dim returns the dimensionality of the embeddings.
+func (d *DenseStorage) Dim() int {
+	return d.dim
+}
+
+// Raw returns the underlying row-major embedding matrix, for use with
+// BLAS routines.
+func (d *DenseStorage) Raw() []float32 {
+	return d.matrix
+}
+
+// PQStorage is a product-quantized Storage. The embedding dimension is
+// split into M equal-sized subspaces, each with its own codebook of K
+// centroids. Every embedding is stored as M byte-sized codes, each of
+// which refers to a centroid in the corresponding subspace's codebook.
+// This trades a small loss in accuracy for a large reduction in memory
+// use: a 300-dimensional embedding stored as 4-byte floats takes 1200
+// bytes, while its PQ8 encoding takes just 8 bytes plus a shared,
+// amortized codebook.
+type PQStorage struct {
+	codes     []uint8
+	codebooks []float32
+	m         int
+	k         int
+	dim       int
+}
+
+// subDim returns the dimensionality of a single subspace.
+func (p *PQStorage) subDim() int {
+	return p.dim / p.m
+}
+
+// Row decodes and returns the embedding at the given vocabulary index.
+func (p *PQStorage) Row(idx int) []float32 {
+	subDim := p.subDim()
+	row := make([]float32, p.dim)
+
+	for sub := 0; sub < p.m; sub++ {
+		code := p.codes[idx*p.m+sub]
+		centroid := p.centroid(sub, int(code))
+		copy(row[sub*subDim:(sub+1)*subDim], centroid)
+	}
+
+	return row
+}
+
+// Size returns the number of embeddings in the storage.
+func (p *PQStorage) Size() int {
+	if p.m == 0 {
+		return 0
+	}
+	return len(p.codes) / p.m
+}
+
+// Dim returns the dimensionality of the embeddings.
+func (p *PQStorage) Dim() int {
+	return p.dim
+}
+
+// centroid returns the centroid of the k'th cluster of the given
+// subspace.
+func (p *PQStorage) centroid(sub, k int) []float32 {
+	subDim := p.subDim()
+	start := (sub*p.k + k) * subDim
+	return p.codebooks[start : start+subDim]
+}
+
+// scoreAll computes the dot product of query with every stored
+// embedding, using asymmetric distance computation: a table of dot
+// products between each subspace of query and that subspace's centroids
+// is precomputed once, after which every stored word is scored by
+// summing M table lookups.
+func (p *PQStorage) scoreAll(query Embedding) []float32 {
+	subDim := p.subDim()
+
+	table := make([][]float32, p.m)
+	for sub := 0; sub < p.m; sub++ {
+		qsub := query[sub*subDim : (sub+1)*subDim]
+
+		row := make([]float32, p.k)
+		for k := 0; k < p.k; k++ {
+			row[k] = dotProduct(qsub, p.centroid(sub, k))
+		}
+		table[sub] = row
+	}
+
+	nWords := p.Size()
+	scores := make([]float32, nWords)
+	for idx := 0; idx < nWords; idx++ {
+		var sum float32
+		for sub := 0; sub < p.m; sub++ {
+			sum += table[sub][p.codes[idx*p.m+sub]]
+		}
+		scores[idx] = sum
+	}
+
+	return scores
+}
+
+// TrainPQ builds a product-quantized copy of embeds. The embedding
+// dimension is split into m equal-size subspaces, each quantized with a
+// codebook of k centroids that is learned with iters iterations of
+// k-means. embeds must use dense storage, and its dimensionality must be
+// a multiple of m.
+func TrainPQ(embeds *Embeddings, m, k, iters int) (*Embeddings, error) {
+	dense, ok := embeds.storage.(*DenseStorage)
+	if !ok {
+		return nil, fmt.Errorf("go2vec: TrainPQ requires embeddings with dense storage")
+	}
+
+	if m <= 0 {
+		return nil, fmt.Errorf("go2vec: m (%d) must be positive", m)
+	}
+
+	if k <= 0 || k > 256 {
+		return nil, fmt.Errorf("go2vec: k (%d) must be in (0, 256], since codes are stored as a single byte", k)
+	}
+
+	dim := dense.Dim()
+	if dim%m != 0 {
+		return nil, fmt.Errorf("go2vec: embedding dimensionality %d is not a multiple of m (%d)", dim, m)
+	}
+
+	subDim := dim / m
+	nWords := dense.Size()
+
+	codebooks := make([]float32, m*k*subDim)
+	codes := make([]uint8, nWords*m)
+
+	for sub := 0; sub < m; sub++ {
+		vectors := make([][]float32, nWords)
+		for idx := 0; idx < nWords; idx++ {
+			row := dense.Row(idx)
+			vectors[idx] = row[sub*subDim : (sub+1)*subDim]
+		}
+
+		centroids, assignments := kMeans(vectors, k, iters)
+
+		copy(codebooks[sub*k*subDim:(sub+1)*k*subDim], flatten(centroids))
+		for idx, cluster := range assignments {
+			codes[idx*m+sub] = uint8(cluster)
+		}
+	}
+
+	storage := &PQStorage{
+		codes:     codes,
+		codebooks: codebooks,
+		m:         m,
+		k:         k,
+		dim:       dim,
+	}
+
+	return &Embeddings{
+		blas:      embeds.blas,
+		storage:   storage,
+		embedSize: embeds.embedSize,
+		indices:   embeds.indices,
+		words:     embeds.words,
+	}, nil
+}
+
+// kMeans clusters vectors into k clusters using iters iterations of
+// Lloyd's algorithm, returning the final centroids and the cluster
+// assignment for every vector.
+func kMeans(vectors [][]float32, k, iters int) ([][]float32, []int) {
+	dim := len(vectors[0])
+
+	centroids := make([][]float32, k)
+	for i := range centroids {
+		src := vectors[rand.Intn(len(vectors))]
+		centroid := make([]float32, dim)
+		copy(centroid, src)
+		centroids[i] = centroid
+	}
+
+	assignments := make([]int, len(vectors))
+
+	for iter := 0; iter < iters; iter++ {
+		for idx, v := range vectors {
+			assignments[idx] = nearestCentroid(v, centroids)
+		}
+
+		sums := make([][]float32, k)
+		counts := make([]int, k)
+		for i := range sums {
+			sums[i] = make([]float32, dim)
+		}
+
+		for idx, v := range vectors {
+			cluster := assignments[idx]
+			counts[cluster]++
+			for d, val := range v {
+				sums[cluster][d] += val
+			}
+		}
+
+		for i, count := range counts {
+			if count == 0 {
+				continue
+			}
+			for d := range sums[i] {
+				centroids[i][d] = sums[i][d] / float32(count)
+			}
+		}
+	}
+
+	return centroids, assignments
+}
+
+// nearestCentroid returns the index of the centroid that is closest to v
+// in Euclidean distance.
+func nearestCentroid(v []float32, centroids [][]float32) int {
+	best := 0
+	bestDist := float32(math.MaxFloat32)
+
+	for i, centroid := range centroids {
+		var dist float32
+		for d, val := range v {
+			diff := val - centroid[d]
+			dist += diff * diff
+		}
+
+		if dist < bestDist {
+			bestDist = dist
+			best = i
+		}
+	}
+
+	return best
+}
+
+// flatten concatenates a slice of equal-length float32 slices into one.
+func flatten(vectors [][]float32) []float32 {
+	if len(vectors) == 0 {
+		return nil
+	}
+
+	dim := len(vectors[0])
+	flat := make([]float32, len(vectors)*dim)
+	for i, v := range vectors {
+		copy(flat[i*dim:(i+1)*dim], v)
+	}
+
+	return flat
+}
+
+// pqBinMagic marks the start of a product-quantized embeddings file
+// written by this package.
+var pqBinMagic = [4]byte{0x89, 'P', 'Q', 'B'}
+
+// ReadPQBinary reads product-quantized embeddings that were written with
+// WriteEmbeddings using FormatPQBinary.
+func ReadPQBinary(r *bufio.Reader, opts ReadOptions) (*Embeddings, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != pqBinMagic {
+		return nil, fmt.Errorf("go2vec: not a product-quantized binary file")
+	}
+
+	var nWords uint64
+	if _, err := fmt.Fscanf(r, "%d", &nWords); err != nil {
+		return nil, err
+	}
+
+	var dim uint64
+	if _, err := fmt.Fscanf(r, "%d", &dim); err != nil {
+		return nil, err
+	}
+
+	// Fscanf does not consume the newline that terminates the header
+	// line, since the fields that follow it are fixed-size binary rather
+	// than text. Skip it before reading those fields.
+	if b, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if !isASCIISpace(b) {
+		if err := r.UnreadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	var m, k uint32
+	if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &k); err != nil {
+		return nil, err
+	}
+
+	n := int(nWords)
+	if opts.MaxWords > 0 && opts.MaxWords < n {
+		n = opts.MaxWords
+	}
+
+	indices := make(map[string]int)
+	words := make([]string, 0, n)
+	codes := make([]uint8, 0, n*int(m))
+
+	for idx := 0; idx < n; idx++ {
+		word, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		word = strings.TrimSpace(word)
+
+		rowCodes := make([]uint8, m)
+		if _, err := io.ReadFull(r, rowCodes); err != nil {
+			return nil, err
+		}
+
+		indices[word] = len(words)
+		words = append(words, word)
+		codes = append(codes, rowCodes...)
+	}
+
+	for idx := n; idx < int(nWords); idx++ {
+		if _, err := r.ReadString(' '); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, make([]uint8, m)); err != nil {
+			return nil, err
+		}
+	}
+
+	codebooks := make([]float32, uint64(m)*uint64(k)*(dim/uint64(m)))
+	if err := binary.Read(r, binary.LittleEndian, codebooks); err != nil {
+		return nil, err
+	}
+
+	return &Embeddings{
+		blas: cblasImplementation(),
+		storage: &PQStorage{
+			codes:     codes,
+			codebooks: codebooks,
+			m:         int(m),
+			k:         int(k),
+			dim:       int(dim),
+		},
+		embedSize: int(dim),
+		indices:   indices,
+		words:     words,
+	}, nil
+}
+
+func writePQBinary(w *bufio.Writer, embeds *Embeddings) error {
+	pq, ok := embeds.storage.(*PQStorage)
+	if !ok {
+		return fmt.Errorf("go2vec: embeddings do not use product-quantized storage")
+	}
+
+	if _, err := w.Write(pqBinMagic[:]); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%d %d\n", len(embeds.words), pq.dim); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(pq.m)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(pq.k)); err != nil {
+		return err
+	}
+
+	for idx, word := range embeds.words {
+		if _, err := w.WriteString(word + " "); err != nil {
+			return err
+		}
+		start := idx * pq.m
+		if _, err := w.Write(pq.codes[start : start+pq.m]); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(w, binary.LittleEndian, pq.codebooks)
+}
@@ -0,0 +1,130 @@
+package go2vec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTrainPQ(t *testing.T) {
+	embeds := NewEmbeddings(4)
+	embeds.Put("apple", []float32{1.0, 0.0, 1.0, 0.0})
+	embeds.Put("pear", []float32{0.9, 0.1, 0.8, 0.2})
+	embeds.Put("car", []float32{0.0, 1.0, 0.0, 1.0})
+	embeds.Put("truck", []float32{0.1, 0.9, 0.2, 0.8})
+
+	pqEmbeds, err := TrainPQ(embeds, 2, 2, 10)
+	if err != nil {
+		t.Fatalf("TrainPQ should not fail, was: %s", err)
+	}
+
+	if pqEmbeds.Size() != embeds.Size() {
+		t.Errorf("Quantized embeddings should have size %d, was %d", embeds.Size(), pqEmbeds.Size())
+	}
+
+	if _, ok := pqEmbeds.storage.(*PQStorage); !ok {
+		t.Error("TrainPQ should produce embeddings backed by PQStorage")
+	}
+
+	if _, err := pqEmbeds.Similarity("apple", 2); err != nil {
+		t.Errorf("Similarity on quantized embeddings should not fail, was: %s", err)
+	}
+}
+
+func TestTrainPQRequiresDenseStorage(t *testing.T) {
+	embeds := NewEmbeddings(4)
+	embeds.Put("apple", []float32{1.0, 0.0, 1.0, 0.0})
+
+	quantized, err := TrainPQ(embeds, 2, 2, 1)
+	if err != nil {
+		t.Fatalf("TrainPQ should not fail, was: %s", err)
+	}
+
+	if _, err := TrainPQ(quantized, 2, 2, 1); err == nil {
+		t.Error("TrainPQ on already-quantized embeddings should fail")
+	}
+}
+
+func TestTrainPQRequiresPositiveM(t *testing.T) {
+	embeds := NewEmbeddings(4)
+	embeds.Put("apple", []float32{1.0, 0.0, 1.0, 0.0})
+
+	if _, err := TrainPQ(embeds, 0, 2, 1); err == nil {
+		t.Error("TrainPQ with m == 0 should fail")
+	}
+}
+
+func TestTrainPQRequiresValidK(t *testing.T) {
+	embeds := NewEmbeddings(4)
+	embeds.Put("apple", []float32{1.0, 0.0, 1.0, 0.0})
+
+	if _, err := TrainPQ(embeds, 2, 0, 1); err == nil {
+		t.Error("TrainPQ with k == 0 should fail")
+	}
+
+	if _, err := TrainPQ(embeds, 2, 257, 1); err == nil {
+		t.Error("TrainPQ with k > 256 should fail, since codes are stored as a single byte")
+	}
+}
+
+func TestWriteReadPQBinary(t *testing.T) {
+	embeds := NewEmbeddings(4)
+	embeds.Put("apple", []float32{1.0, 0.0, 1.0, 0.0})
+	embeds.Put("pear", []float32{0.9, 0.1, 0.8, 0.2})
+	embeds.Put("car", []float32{0.0, 1.0, 0.0, 1.0})
+	embeds.Put("truck", []float32{0.1, 0.9, 0.2, 0.8})
+
+	pqEmbeds, err := TrainPQ(embeds, 2, 2, 10)
+	if err != nil {
+		t.Fatalf("TrainPQ should not fail, was: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEmbeddings(&buf, FormatPQBinary, pqEmbeds); err != nil {
+		t.Fatalf("WriteEmbeddings should not fail, was: %s", err)
+	}
+
+	roundtripped, err := ReadEmbeddings(&buf, FormatPQBinary, ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadEmbeddings should not fail, was: %s", err)
+	}
+
+	pq, ok := pqEmbeds.storage.(*PQStorage)
+	if !ok {
+		t.Fatal("TrainPQ should produce embeddings backed by PQStorage")
+	}
+
+	roundtrippedPQ, ok := roundtripped.storage.(*PQStorage)
+	if !ok {
+		t.Fatal("Roundtripped embeddings should be backed by PQStorage")
+	}
+
+	if roundtrippedPQ.m != pq.m {
+		t.Errorf("Roundtripped m should be %d, was %d", pq.m, roundtrippedPQ.m)
+	}
+
+	if roundtrippedPQ.k != pq.k {
+		t.Errorf("Roundtripped k should be %d, was %d", pq.k, roundtrippedPQ.k)
+	}
+
+	if roundtripped.Size() != pqEmbeds.Size() {
+		t.Errorf("Roundtripped embeddings should have size %d, was %d", pqEmbeds.Size(), roundtripped.Size())
+	}
+
+	query, ok := embeds.Embedding("apple")
+	if !ok {
+		t.Fatal("'apple' should be known")
+	}
+
+	wantScores := pq.scoreAll(query)
+	gotScores := roundtrippedPQ.scoreAll(query)
+
+	if len(gotScores) != len(wantScores) {
+		t.Fatalf("Roundtripped scores should have length %d, was %d", len(wantScores), len(gotScores))
+	}
+
+	for idx, want := range wantScores {
+		if gotScores[idx] != want {
+			t.Errorf("Score at index %d should be %f, was %f", idx, want, gotScores[idx])
+		}
+	}
+}
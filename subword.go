@@ -0,0 +1,260 @@
+// Copyright 2015 Daniël de Kok
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package go2vec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// SubwordIndexer provides the character n-grams that are used to look up
+// subword embeddings for a word. Implementations are used by Embeddings
+// to synthesize embeddings for words that are not in the vocabulary.
+type SubwordIndexer interface {
+	// NGrams returns the character n-grams for the given word.
+	NGrams(word string) []string
+}
+
+// NGramIndexer is a SubwordIndexer that generates fastText-style character
+// n-grams. Words are bracketed with '<' and '>' before n-grams of length
+// minN up to and including maxN are extracted.
+type NGramIndexer struct {
+	minN int
+	maxN int
+}
+
+// NewNGramIndexer constructs a SubwordIndexer that extracts n-grams of
+// length minN up to and including maxN.
+func NewNGramIndexer(minN, maxN int) *NGramIndexer {
+	return &NGramIndexer{
+		minN: minN,
+		maxN: maxN,
+	}
+}
+
+// MinN returns the shortest n-gram length that is extracted.
+func (idx *NGramIndexer) MinN() int {
+	return idx.minN
+}
+
+// MaxN returns the longest n-gram length that is extracted.
+func (idx *NGramIndexer) MaxN() int {
+	return idx.maxN
+}
+
+// NGrams returns the bracketed character n-grams of the given word.
+func (idx *NGramIndexer) NGrams(word string) []string {
+	runes := []rune(bracketWord(word))
+
+	var ngrams []string
+	for n := idx.minN; n <= idx.maxN; n++ {
+		if n > len(runes) {
+			break
+		}
+
+		for start := 0; start+n <= len(runes); start++ {
+			ngrams = append(ngrams, string(runes[start:start+n]))
+		}
+	}
+
+	return ngrams
+}
+
+// bracketWord adds the '<' and '>' markers that fastText uses to delimit
+// a word before extracting its n-grams.
+func bracketWord(word string) string {
+	return "<" + word + ">"
+}
+
+// fastTextBinMagic marks the start of a fastText binary file written by
+// this package, so that SniffFormat can tell it apart from a plain
+// word2vec binary file.
+var fastTextBinMagic = [4]byte{0x89, 'F', 'T', 'B'}
+
+// ReadFastTextBinary reads word embeddings from a fastText-style binary
+// file in this package's own layout, as written by WriteEmbeddings with
+// FormatFastTextBin. Files that do not start with fastTextBinMagic, such
+// as the ".bin" files that fastText itself writes, are rejected with an
+// error. In addition to the in-vocabulary word embeddings, the n-gram
+// buckets that fastText uses to represent subwords are read, so that
+// Similarity, Analogy and Embedding can synthesize embeddings for words
+// that are not in the vocabulary.
+//
+// This is a convenience wrapper around ReadEmbeddings for the common case.
+func ReadFastTextBinary(r *bufio.Reader, normalize bool) (*Embeddings, error) {
+	return readFastTextBinary(r, ReadOptions{Normalize: normalize})
+}
+
+func readFastTextBinary(r *bufio.Reader, opts ReadOptions) (*Embeddings, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != fastTextBinMagic {
+		return nil, fmt.Errorf("go2vec: not a fastText binary file")
+	}
+
+	var nWords uint64
+	if _, err := fmt.Fscanf(r, "%d", &nWords); err != nil {
+		return nil, err
+	}
+
+	var vSize uint64
+	if _, err := fmt.Fscanf(r, "%d", &vSize); err != nil {
+		return nil, err
+	}
+
+	// Fscanf does not consume the newline that terminates the header
+	// line, since the fields that follow it are fixed-size binary rather
+	// than text. Skip it before reading those fields.
+	if b, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if !isASCIISpace(b) {
+		if err := r.UnreadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	var minN, maxN, buckets uint32
+	if err := binary.Read(r, binary.LittleEndian, &minN); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &maxN); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &buckets); err != nil {
+		return nil, err
+	}
+
+	n := int(nWords)
+	if opts.MaxWords > 0 && opts.MaxWords < n {
+		n = opts.MaxWords
+	}
+
+	matrix := make([]float32, 0, n*int(vSize))
+	indices := make(map[string]int)
+	words := make([]string, 0, n)
+
+	for idx := 0; idx < n; idx++ {
+		word, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		word = strings.TrimSpace(word)
+
+		embedding := make([]float32, vSize)
+		// Lossy only applies to the text formats: a fastText binary row
+		// that cannot be read leaves the following rows, and the n-gram
+		// bucket matrix after them, misaligned, so it is always an error.
+		if err := binary.Read(r, binary.LittleEndian, embedding); err != nil {
+			return nil, err
+		}
+
+		if opts.Normalize {
+			normalizeEmbeddings(embedding)
+		}
+
+		indices[word] = len(words)
+		words = append(words, word)
+		matrix = append(matrix, embedding...)
+	}
+
+	// Skip over any remaining in-vocabulary rows that were not read
+	// because of a MaxWords cap, so that the n-gram bucket matrix that
+	// follows is aligned correctly.
+	for idx := n; idx < int(nWords); idx++ {
+		if _, err := r.ReadString(' '); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(vSize)*4); err != nil {
+			return nil, err
+		}
+	}
+
+	ngramMatrix := make([]float32, uint64(buckets)*vSize)
+	if err := binary.Read(r, binary.LittleEndian, ngramMatrix); err != nil {
+		return nil, err
+	}
+
+	return &Embeddings{
+		blas:           cblasImplementation(),
+		storage:        NewDenseStorage(matrix, int(vSize)),
+		embedSize:      int(vSize),
+		indices:        indices,
+		words:          words,
+		subwordIndexer: NewNGramIndexer(int(minN), int(maxN)),
+		ngramMatrix:    ngramMatrix,
+		nBuckets:       int(buckets),
+	}, nil
+}
+
+// ngramHash computes the 32-bit FNV-1a hash that fastText uses to map an
+// n-gram to a bucket.
+func ngramHash(ngram string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(ngram))
+	return h.Sum32()
+}
+
+// embeddingFor looks up the embedding for a word, falling back to a
+// subword-derived embedding when the word is not in the vocabulary. The
+// second return value is the index of the word in the vocabulary, or -1
+// if the embedding was synthesized from subwords.
+func (e *Embeddings) embeddingFor(word string) (Embedding, int, error) {
+	if idx, ok := e.indices[word]; ok {
+		return e.lookupIdx(idx), idx, nil
+	}
+
+	embed, err := e.synthesizeEmbedding(word)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return embed, -1, nil
+}
+
+// synthesizeEmbedding builds an embedding for an out-of-vocabulary word
+// from its n-gram buckets. It returns an error if no subword index is
+// available, or none of the word's n-grams could be looked up.
+func (e *Embeddings) synthesizeEmbedding(word string) (Embedding, error) {
+	if e.subwordIndexer == nil {
+		return nil, fmt.Errorf("go2vec: unknown word: %s", word)
+	}
+
+	sum := make([]float32, e.embedSize)
+	found := false
+
+	for _, ngram := range e.subwordIndexer.NGrams(word) {
+		bucket := ngramHash(ngram) % uint32(e.nBuckets)
+		start := int(bucket) * e.embedSize
+		row := e.ngramMatrix[start : start+e.embedSize]
+		for i, val := range row {
+			sum[i] += val
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("go2vec: unknown word: %s", word)
+	}
+
+	normalizeEmbeddings(sum)
+	return sum, nil
+}
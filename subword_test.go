@@ -0,0 +1,109 @@
+package go2vec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestNGramIndexer(t *testing.T) {
+	indexer := NewNGramIndexer(3, 4)
+
+	ngrams := indexer.NGrams("wo")
+	expected := []string{"<wo", "wo>", "<wo>"}
+	if !reflect.DeepEqual(ngrams, expected) {
+		t.Errorf("NGrams(\"wo\") should be %v, was %v", expected, ngrams)
+	}
+
+	ngrams = indexer.NGrams("word")
+	expected = []string{"<wo", "wor", "ord", "rd>", "<wor", "word", "ord>"}
+	if !reflect.DeepEqual(ngrams, expected) {
+		t.Errorf("NGrams(\"word\") should be %v, was %v", expected, ngrams)
+	}
+}
+
+func TestNGramIndexerUTF8(t *testing.T) {
+	indexer := NewNGramIndexer(3, 3)
+
+	ngrams := indexer.NGrams("Köln")
+	expected := []string{"<Kö", "Köl", "öln", "ln>"}
+	if !reflect.DeepEqual(ngrams, expected) {
+		t.Errorf("NGrams(\"Köln\") should be %v, was %v", expected, ngrams)
+	}
+}
+
+// writeTempFastTextBinary builds a minimal fastText binary fixture with
+// two in-vocabulary words and a small n-gram bucket matrix, so that
+// synthesizeEmbedding has something to look up for an OOV query.
+func writeTempFastTextBinary(t *testing.T) *bytes.Buffer {
+	const vSize = 2
+	const buckets = 100
+
+	var buf bytes.Buffer
+	buf.Write(fastTextBinMagic[:])
+	buf.WriteString("2 2\n")
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(buckets)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeRow := func(word string, row []float32) {
+		buf.WriteString(word)
+		buf.WriteString(" ")
+		if err := binary.Write(&buf, binary.LittleEndian, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeRow("apple", []float32{1.0, 0.0})
+	writeRow("pear", []float32{0.8, 0.1})
+
+	ngramMatrix := make([]float32, buckets*vSize)
+	for i := range ngramMatrix {
+		ngramMatrix[i] = 0.1
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, ngramMatrix); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestReadFastTextBinaryOOV(t *testing.T) {
+	embeds, err := ReadFastTextBinary(bufio.NewReader(writeTempFastTextBinary(t)), true)
+	if err != nil {
+		t.Fatalf("ReadFastTextBinary should not fail, was: %s", err)
+	}
+
+	if embeds.Size() != 2 {
+		t.Errorf("Embeddings should have size 2, was %d", embeds.Size())
+	}
+
+	embedding, ok := embeds.Embedding("apples")
+	if !ok {
+		t.Fatal("'apples' should be synthesizable from n-grams")
+	}
+	if len(embedding) != 2 {
+		t.Errorf("Synthesized embedding should have size 2, was %d", len(embedding))
+	}
+
+	if _, ok := embeds.Embedding("banana"); !ok {
+		t.Error("'banana' should be synthesizable from n-grams")
+	}
+
+	similar, err := embeds.Similarity("apples", 1)
+	if err != nil {
+		t.Fatalf("Similarity should not fail, was: %s", err)
+	}
+	if len(similar) != 1 {
+		t.Errorf("Similarity for an OOV word should return a result, got %v", similar)
+	}
+}